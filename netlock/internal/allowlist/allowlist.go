@@ -0,0 +1,192 @@
+// Package allowlist models named, composable groups of allowed and denied
+// CIDRs (e.g. "corp", "vpn-a", "lan"), loaded from a YAML or JSON file, with
+// optional per-interface overrides ("on wg0, also allow 10.0.0.0/8"). A
+// Tree6 resolves overlapping CIDRs across groups by longest-prefix-match, so
+// a narrower deny inside a wider allow (or vice versa) always wins.
+package allowlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Group is a named set of allowed (and explicitly denied) CIDRs.
+type Group struct {
+	Name  string
+	Allow []string
+	Deny  []string
+}
+
+// InterfaceOverride adds extra allowed CIDRs that only apply when traffic is
+// on the named interface.
+type InterfaceOverride struct {
+	Interface string
+	Allow     []string
+}
+
+// List is a parsed, validated allowlist configuration: one or more named
+// Groups plus any per-interface Overrides, backed by a Tree6 so overlapping
+// allow/deny CIDRs across groups resolve by longest-prefix-match instead of
+// by declaration order.
+type List struct {
+	Groups    []Group
+	Overrides []InterfaceOverride
+	tree      *Tree6
+}
+
+// New returns an empty List, ready for SetGroup calls. Callers that have no
+// -allowlist file still get a valid, queryable List this way.
+func New() *List {
+	return &List{tree: NewTree6()}
+}
+
+// SetGroup adds allow to the named group, creating it if it doesn't already
+// exist. It's how callers fold CLI-provided destinations into the same
+// group/table machinery as file-defined groups.
+func (l *List) SetGroup(name string, allow []string) error {
+	for _, addr := range allow {
+		cidr, err := normalizeCIDR(addr)
+		if err != nil {
+			return err
+		}
+		if err := l.tree.Insert(cidr, name, false); err != nil {
+			return err
+		}
+	}
+	for i, group := range l.Groups {
+		if group.Name == name {
+			l.Groups[i].Allow = append(l.Groups[i].Allow, allow...)
+			return nil
+		}
+	}
+	l.Groups = append(l.Groups, Group{Name: name, Allow: allow})
+	return nil
+}
+
+// Entries returns every allow/deny CIDR across all groups, most specific
+// (longest prefix) first, so a caller rendering them as first-match rules
+// gets real longest-prefix-match resolution instead of "all denies before
+// all allows."
+func (l *List) Entries() []Entry {
+	return l.tree.Entries()
+}
+
+// normalizeCIDR returns addr as a CIDR, widening a bare IP to a /32 (IPv4)
+// or /128 (IPv6) host route so it inserts into a Tree6 like any other entry.
+// It re-renders ip rather than reusing addr's own text, so a v4-in-v6
+// literal like "::ffff:1.2.3.4" normalizes to the single host "1.2.3.4/32"
+// instead of "::ffff:1.2.3.4/32", whose /32 covers the whole ::/32 network.
+func normalizeCIDR(addr string) (string, error) {
+	if _, _, err := net.ParseCIDR(addr); err == nil {
+		return addr, nil
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("allowlist: %q: invalid CIDR address", addr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+type fileGroup struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+}
+
+type fileInterface struct {
+	Allow []string `yaml:"allow" json:"allow"`
+}
+
+type fileConfig struct {
+	Groups     map[string]fileGroup     `yaml:"groups" json:"groups"`
+	Interfaces map[string]fileInterface `yaml:"interfaces" json:"interfaces"`
+}
+
+// Load parses a YAML or JSON allowlist file, chosen by its extension
+// (.yaml/.yml vs .json), and validates that every CIDR parses.
+func Load(path string) (*List, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg fileConfig
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		return nil, fmt.Errorf("allowlist: unsupported extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("allowlist: %s: %w", path, err)
+	}
+	return newList(cfg)
+}
+
+func newList(cfg fileConfig) (*List, error) {
+	list := New()
+	for _, name := range sortedKeys(cfg.Groups) {
+		group := cfg.Groups[name]
+		for _, addr := range group.Allow {
+			cidr, err := normalizeCIDR(addr)
+			if err != nil {
+				return nil, fmt.Errorf("allowlist: group %q: %w", name, err)
+			}
+			if err := list.tree.Insert(cidr, name, false); err != nil {
+				return nil, err
+			}
+		}
+		for _, addr := range group.Deny {
+			cidr, err := normalizeCIDR(addr)
+			if err != nil {
+				return nil, fmt.Errorf("allowlist: group %q: %w", name, err)
+			}
+			if err := list.tree.Insert(cidr, name, true); err != nil {
+				return nil, err
+			}
+		}
+		list.Groups = append(list.Groups, Group{Name: name, Allow: group.Allow, Deny: group.Deny})
+	}
+	for _, iface := range sortedKeys(cfg.Interfaces) {
+		override := cfg.Interfaces[iface]
+		for _, cidr := range override.Allow {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("allowlist: interface %q: %w", iface, err)
+			}
+		}
+		list.Overrides = append(list.Overrides, InterfaceOverride{Interface: iface, Allow: override.Allow})
+	}
+	return list, nil
+}
+
+// sortedKeys returns m's keys in ascending order, so callers that range over
+// a map get the same, reproducible order on every run instead of Go's
+// randomized map iteration.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Group returns the named group, if it exists.
+func (l *List) Group(name string) (Group, bool) {
+	for _, group := range l.Groups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
@@ -0,0 +1,114 @@
+package allowlist
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Entry is one CIDR inserted into a Tree6, carrying enough to render it back
+// out as a block/pass rule in prefix-length order.
+type Entry struct {
+	CIDR string
+	Name string
+	Deny bool
+	bits int
+}
+
+// Tree6 is a binary trie over IPv4 and IPv6 address bits, used to resolve
+// overlapping allow/deny CIDRs by longest-prefix-match: whichever entry most
+// specifically contains an address wins, regardless of insertion order or
+// whether it was inserted as an allow or a deny.
+type Tree6 struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+// NewTree6 returns an empty Tree6.
+func NewTree6() *Tree6 {
+	return &Tree6{v4: newTrieNode(), v6: newTrieNode()}
+}
+
+// Insert adds cidr under name as an allow or deny entry. A less specific
+// prefix inserted after a more specific one does not overwrite it; Entries
+// resolves ties by specificity, not insertion order. Insert returns an error
+// if another entry was already inserted at the exact same address/prefix,
+// since that's ambiguous and one would otherwise silently shadow the other.
+func (t *Tree6) Insert(cidr string, name string, deny bool) error {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("allowlist: %q: %w", cidr, err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	entry := Entry{CIDR: cidr, Name: name, Deny: deny, bits: ones}
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4.insert(ip4, ones, entry)
+	}
+	return t.v6.insert(ip.To16(), ones, entry)
+}
+
+// Entries returns every inserted entry, most specific (longest prefix)
+// first, with ties broken by CIDR then name so the order is deterministic
+// across runs. Rendering entries as first-match "quick" rules in this order
+// gives correct longest-prefix-match semantics without needing a runtime
+// Lookup per packet.
+func (t *Tree6) Entries() []Entry {
+	var entries []Entry
+	t.v4.collect(&entries)
+	t.v6.collect(&entries)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].bits != entries[j].bits {
+			return entries[i].bits > entries[j].bits
+		}
+		if entries[i].CIDR != entries[j].CIDR {
+			return entries[i].CIDR < entries[j].CIDR
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	entry    Entry
+	terminal bool
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{}
+}
+
+func (n *trieNode) insert(addr []byte, prefixLen int, entry Entry) error {
+	node := n
+	for i := 0; i < prefixLen; i++ {
+		bit := bitAt(addr, i)
+		if node.children[bit] == nil {
+			node.children[bit] = newTrieNode()
+		}
+		node = node.children[bit]
+	}
+	if node.terminal {
+		return fmt.Errorf(
+			"allowlist: %q and %q both claim %s, ambiguous",
+			node.entry.Name, entry.Name, entry.CIDR,
+		)
+	}
+	node.entry = entry
+	node.terminal = true
+	return nil
+}
+
+func (n *trieNode) collect(out *[]Entry) {
+	if n == nil {
+		return
+	}
+	if n.terminal {
+		*out = append(*out, n.entry)
+	}
+	n.children[0].collect(out)
+	n.children[1].collect(out)
+}
+
+func bitAt(addr []byte, i int) byte {
+	return (addr[i/8] >> (7 - uint(i%8))) & 1
+}
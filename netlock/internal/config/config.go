@@ -0,0 +1,151 @@
+// Package config loads netlock's optional config file: a default block plus
+// named profiles ("home", "cafe", "vpn-only", ...) a user can switch between
+// with -profile instead of retyping flags every time.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is one profile's (or the file's default) worth of settings. The
+// zero value of every field means "not set, fall through to whatever's
+// already there" so File.Select and CLI-flag overrides can be applied as
+// simple field-by-field merges. Booleans that a profile must be able to
+// explicitly turn back off (unlike the default block) are *bool: nil means
+// "not set", as opposed to a set-to-false override.
+type Config struct {
+	DefaultConfigurationPath string        `yaml:"default_configuration_path"`
+	Backend                  string        `yaml:"backend"`
+	AllowIncoming            *bool         `yaml:"allow_incoming"`
+	AllowOutgoing            *bool         `yaml:"allow_outgoing"`
+	AllowPrivateNetworks     *bool         `yaml:"allow_private_networks"`
+	AllowICMP                *bool         `yaml:"allow_icmp"`
+	Interfaces               []string      `yaml:"interfaces"`
+	Destinations             []string      `yaml:"destinations"`
+	VPNConfigFiles           []string      `yaml:"vpn_config_files"`
+	AllowlistPath            string        `yaml:"allowlist"`
+	PassUsers                []string      `yaml:"pass_users"`
+	PassGroups               []string      `yaml:"pass_groups"`
+	Daemon                   *bool         `yaml:"daemon"`
+	ResolveInterval          time.Duration `yaml:"resolve_interval"`
+}
+
+// Bool reports the value b points to, or false if b is nil (not set).
+func Bool(b *bool) bool {
+	return b != nil && *b
+}
+
+// File is the on-disk shape of a config file: an optional default block
+// applied regardless of -profile, plus named profiles layered on top of it.
+type File struct {
+	Default  Config            `yaml:"default"`
+	Profiles map[string]Config `yaml:"profiles"`
+}
+
+// SearchPaths returns the implicit config file locations netlock checks when
+// -config isn't given, in lookup order.
+func SearchPaths() []string {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "netlock", "config.yaml"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "netlock", "config.yaml"))
+	}
+	paths = append(paths, "/etc/netlock/config.yaml")
+	return paths
+}
+
+// Load parses the config file at path.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// Find loads explicitPath if given, otherwise the first existing path from
+// SearchPaths, otherwise an empty File so callers can proceed with CLI flags
+// alone.
+func Find(explicitPath string) (*File, error) {
+	if explicitPath != "" {
+		return Load(explicitPath)
+	}
+	for _, path := range SearchPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return Load(path)
+		}
+	}
+	return &File{}, nil
+}
+
+// Select returns the default block with the named profile merged over it.
+// An empty profile name returns just the default block.
+func (f *File) Select(profile string) Config {
+	cfg := f.Default
+	if profile == "" {
+		return cfg
+	}
+	if override, ok := f.Profiles[profile]; ok {
+		cfg = merge(cfg, override)
+	}
+	return cfg
+}
+
+// merge layers override's set fields on top of base; a field left at its
+// zero value in override leaves base's value in place.
+func merge(base, override Config) Config {
+	merged := base
+	if override.DefaultConfigurationPath != "" {
+		merged.DefaultConfigurationPath = override.DefaultConfigurationPath
+	}
+	if override.Backend != "" {
+		merged.Backend = override.Backend
+	}
+	if override.AllowIncoming != nil {
+		merged.AllowIncoming = override.AllowIncoming
+	}
+	if override.AllowOutgoing != nil {
+		merged.AllowOutgoing = override.AllowOutgoing
+	}
+	if override.AllowPrivateNetworks != nil {
+		merged.AllowPrivateNetworks = override.AllowPrivateNetworks
+	}
+	if override.AllowICMP != nil {
+		merged.AllowICMP = override.AllowICMP
+	}
+	if len(override.Interfaces) > 0 {
+		merged.Interfaces = override.Interfaces
+	}
+	if len(override.Destinations) > 0 {
+		merged.Destinations = override.Destinations
+	}
+	if len(override.VPNConfigFiles) > 0 {
+		merged.VPNConfigFiles = override.VPNConfigFiles
+	}
+	if override.AllowlistPath != "" {
+		merged.AllowlistPath = override.AllowlistPath
+	}
+	if len(override.PassUsers) > 0 {
+		merged.PassUsers = override.PassUsers
+	}
+	if len(override.PassGroups) > 0 {
+		merged.PassGroups = override.PassGroups
+	}
+	if override.Daemon != nil {
+		merged.Daemon = override.Daemon
+	}
+	if override.ResolveInterval != 0 {
+		merged.ResolveInterval = override.ResolveInterval
+	}
+	return merged
+}
@@ -0,0 +1,177 @@
+// Package vpnconf parses WireGuard and OpenVPN client configuration files
+// into a typed Config, so callers no longer have to scrape `remote ...` /
+// `Endpoint = ...` lines out of them with a regex.
+package vpnconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Endpoint is a single remote the VPN client connects to.
+type Endpoint struct {
+	Host  string
+	Port  string
+	Proto string
+}
+
+// Config is the union of everything netlock cares about in a VPN client
+// configuration: where it connects to, what it's allowed to route, and what
+// DNS it hands out.
+type Config struct {
+	Endpoints  []Endpoint
+	AllowedIPs []string
+	DNS        []string
+	Addresses  []string
+}
+
+// Parse sniffs fmt from the first non-blank, non-comment line and dispatches
+// to ParseWireGuard or ParseOpenVPN.
+func Parse(r io.Reader) (*Config, error) {
+	var buf strings.Builder
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	content := buf.String()
+	if isWireGuard(content) {
+		return ParseWireGuard(strings.NewReader(content))
+	}
+	return ParseOpenVPN(strings.NewReader(content))
+}
+
+func isWireGuard(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		return strings.HasPrefix(line, "[")
+	}
+	return false
+}
+
+// ParseWireGuard parses the ini-style [Interface]/[Peer] format: section
+// headers, "Key = Value" pairs, case-insensitive keys, "#"/";" comments.
+func ParseWireGuard(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := stripComment(scanner.Text())
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		switch key {
+		case "endpoint":
+			host, port, err := splitHostPort(val)
+			if err != nil {
+				return nil, fmt.Errorf("vpnconf: Endpoint %q: %w", val, err)
+			}
+			cfg.Endpoints = append(cfg.Endpoints, Endpoint{Host: host, Port: port})
+		case "allowedips":
+			cfg.AllowedIPs = append(cfg.AllowedIPs, splitCSV(val)...)
+		case "dns":
+			cfg.DNS = append(cfg.DNS, splitCSV(val)...)
+		case "address":
+			cfg.Addresses = append(cfg.Addresses, splitCSV(val)...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ParseOpenVPN parses the line-oriented ovpn directive format: "remote host
+// port [proto]", <connection> blocks that repeat "remote"/"proto", "route",
+// and "dhcp-option DNS addr".
+func ParseOpenVPN(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := stripComment(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "remote":
+			if len(fields) < 2 {
+				continue
+			}
+			endpoint := Endpoint{Host: fields[1]}
+			if len(fields) > 2 {
+				endpoint.Port = fields[2]
+			}
+			if len(fields) > 3 {
+				endpoint.Proto = fields[3]
+			}
+			cfg.Endpoints = append(cfg.Endpoints, endpoint)
+		case "route":
+			if len(fields) > 1 {
+				route := fields[1]
+				if len(fields) > 2 && net.ParseIP(route) != nil {
+					if mask := net.ParseIP(fields[2]).To4(); mask != nil {
+						if ones, bits := net.IPMask(mask).Size(); bits != 0 {
+							route = fmt.Sprintf("%s/%d", route, ones)
+						}
+					}
+				}
+				cfg.AllowedIPs = append(cfg.AllowedIPs, route)
+			}
+		case "dhcp-option":
+			if len(fields) > 2 && strings.EqualFold(fields[1], "DNS") {
+				cfg.DNS = append(cfg.DNS, fields[2])
+			}
+		case "ifconfig":
+			if len(fields) > 1 {
+				cfg.Addresses = append(cfg.Addresses, fields[1])
+			}
+		case "<connection>", "</connection>":
+			// The remote lines inside a <connection> block are collected
+			// like top-level ones; the markers themselves carry nothing. A
+			// standalone "proto" directive is not read: an Endpoint's Proto
+			// comes only from its own remote line.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func stripComment(line string) string {
+	for _, marker := range []string{"#", ";"} {
+		if idx := strings.Index(line, marker); idx >= 0 {
+			line = line[:idx]
+		}
+	}
+	return line
+}
+
+func splitCSV(val string) []string {
+	var out []string
+	for _, part := range strings.Split(val, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func splitHostPort(val string) (host string, port string, err error) {
+	host, port, ok := strings.Cut(val, ":")
+	if !ok || host == "" || port == "" {
+		return "", "", fmt.Errorf("expected host:port")
+	}
+	return host, port, nil
+}
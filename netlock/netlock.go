@@ -1,25 +1,73 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"os/signal"
+	"os/user"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/x13a/netlock/netlock/internal/allowlist"
+	"github.com/x13a/netlock/netlock/internal/config"
+	"github.com/x13a/netlock/netlock/internal/vpnconf"
 )
 
+// Backend is the contract every firewall backend (pf, nftables, ...) must
+// satisfy so main can stay agnostic of the underlying platform.
+type Backend interface {
+	EnableLock()
+	DisableLock()
+	BuildLockRules() string
+}
+
+// Daemon is implemented by backends that support re-resolving hostname
+// destinations on an interval instead of a one-shot enable. Not every
+// Backend needs to implement it.
+type Daemon interface {
+	RunDaemon(ctx context.Context, interval time.Duration)
+}
+
+// Checker is implemented by backends that can validate the rules they'd
+// install without loading them.
+type Checker interface {
+	Check() error
+}
+
+// Differ is implemented by backends that can diff their would-be rules
+// against what's currently loaded.
+type Differ interface {
+	Diff() string
+}
+
 type (
 	flagDestinationsType struct{}
 	flagInterfacesType   struct{}
 	flagFilesType        struct{}
+	flagPassUsersType    struct{}
+	flagPassGroupsType   struct{}
 )
 
+// PassRule is a single "let this principal out" exception, carved out of an
+// otherwise locked-down ruleset (e.g. "only the VPN user may talk out").
+type PassRule struct {
+	ID    string // resolved uid or gid
+	Proto string
+	Port  string
+}
+
 var (
 	flagEnableLock               bool
 	flagDisableLock              bool
+	flagConfigPath               string
+	flagProfile                  string
 	flagDefaultConfigurationPath string
+	flagBackend                  string
 	flagAllowIncoming            bool
 	flagAllowOutgoing            bool
 	flagAllowPrivateNetworks     bool
@@ -27,9 +75,18 @@ var (
 	flagDestinations             flagDestinationsType
 	flagInterfaces               flagInterfacesType
 	flagFiles                    flagFilesType
+	flagPassUsers                flagPassUsersType
+	flagPassGroups               flagPassGroupsType
 	flagPrintLockRules           bool
+	flagCheck                    bool
+	flagDiff                     bool
+	flagDaemon                   bool
+	flagResolveInterval          time.Duration
+	flagAllowlistPath            string
 	destinations                 []string
 	interfaces                   []string
+	passUserSpecs                []string
+	passGroupSpecs               []string
 )
 
 func setMultiple(dest *[]string, vals string) {
@@ -61,47 +118,126 @@ func (s *flagFilesType) String() string {
 }
 
 func (s *flagFilesType) Set(val string) error {
-	file, err := os.Open(val)
+	hosts, err := vpnConfigDestinations(val)
 	if err != nil {
 		return err
 	}
+	destinations = append(destinations, hosts...)
+	return nil
+}
+
+// vpnConfigDestinations parses a WireGuard/OpenVPN configuration file at
+// path and returns every address traffic needs to reach for the tunnel to
+// work: its endpoints, the subnets it routes (AllowedIPs/route), and the DNS
+// servers it hands out. Default routes are dropped rather than folded in:
+// a full-tunnel "AllowedIPs = 0.0.0.0/0, ::/0" would otherwise turn into a
+// blanket pass-all destination, defeating the kill switch entirely.
+func vpnConfigDestinations(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	subgroup := `([^\s]{4,})`
-	re := regexp.MustCompile(fmt.Sprintf(
-		`(?:remote\s%s|Endpoint\s?=\s?%s:)`,
-		subgroup,
-		subgroup,
-	))
-	for scanner.Scan() {
-		lineSubmatch := re.FindStringSubmatch(scanner.Text())
-		if lineSubmatch == nil {
+	cfg, err := vpnconf.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	hosts := make([]string, 0, len(cfg.Endpoints)+len(cfg.AllowedIPs)+len(cfg.DNS))
+	for _, endpoint := range cfg.Endpoints {
+		hosts = append(hosts, endpoint.Host)
+	}
+	for _, cidr := range cfg.AllowedIPs {
+		if isDefaultRoute(cidr) {
 			continue
 		}
-		var destination string
-		for idx, submatch := range lineSubmatch {
-			if idx != 0 && submatch != "" {
-				destination = submatch
-				break
-			}
-		}
-		destinations = append(destinations, destination)
+		hosts = append(hosts, cidr)
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	hosts = append(hosts, cfg.DNS...)
+	return hosts, nil
+}
+
+// isDefaultRoute reports whether cidr matches every address (IPv4 or IPv6),
+// as seen in WireGuard's "AllowedIPs = 0.0.0.0/0, ::/0" and OpenVPN's
+// "route 0.0.0.0 0.0.0.0" for full-tunnel configurations.
+func isDefaultRoute(cidr string) bool {
+	switch cidr {
+	case "0.0.0.0/0", "::/0", "0.0.0.0":
+		return true
+	default:
+		return false
 	}
+}
+
+func (s *flagPassUsersType) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *flagPassUsersType) Set(val string) error {
+	passUserSpecs = append(passUserSpecs, val)
+	return nil
+}
+
+func (s *flagPassGroupsType) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *flagPassGroupsType) Set(val string) error {
+	passGroupSpecs = append(passGroupSpecs, val)
 	return nil
 }
 
+// newPassRule parses "name[:proto[:port]]" and resolves name to a uid/gid
+// with resolve, failing loudly if the principal is unknown.
+func newPassRule(spec string, resolve func(string) (string, error)) (PassRule, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	id, err := resolve(parts[0])
+	if err != nil {
+		return PassRule{}, fmt.Errorf("resolve %q: %w", parts[0], err)
+	}
+	rule := PassRule{ID: id}
+	if len(parts) > 1 {
+		rule.Proto = parts[1]
+	}
+	if len(parts) > 2 {
+		rule.Port = parts[2]
+	}
+	return rule, nil
+}
+
+func resolvePassRules(specs []string, resolve func(string) (string, error)) []PassRule {
+	rules := make([]PassRule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := newPassRule(spec, resolve)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
 func flagParse() {
 	flag.BoolVar(&flagEnableLock, "e", false, "Enable")
 	flag.BoolVar(&flagDisableLock, "d", false, "Disable")
+	flag.StringVar(
+		&flagConfigPath,
+		"config",
+		"",
+		"Config file path (default: search /etc/netlock/config.yaml and $XDG_CONFIG_HOME/netlock/config.yaml)",
+	)
+	flag.StringVar(&flagProfile, "profile", "", "Named profile to load from the config file")
 	flag.StringVar(
 		&flagDefaultConfigurationPath,
 		"default-configuration-path",
 		"",
 		"Custom default configuration path",
 	)
+	flag.StringVar(
+		&flagBackend,
+		"backend",
+		"",
+		"Firewall backend to use (pf, nft); defaults to the one matching GOOS",
+	)
 	flag.BoolVar(&flagAllowIncoming, "allow-incoming", false, "Allow incoming")
 	flag.BoolVar(&flagAllowOutgoing, "allow-outgoing", false, "Allow outgoing")
 	flag.BoolVar(
@@ -118,7 +254,47 @@ func flagParse() {
 		"file",
 		"Pass to destinations from openvpn/wireguard configuration file",
 	)
+	flag.Var(
+		&flagPassUsers,
+		"pass-user",
+		"Pass outgoing traffic for user, as name[:proto[:port]]",
+	)
+	flag.Var(
+		&flagPassGroups,
+		"pass-group",
+		"Pass outgoing traffic for group, as name[:proto[:port]]",
+	)
 	flag.BoolVar(&flagPrintLockRules, "print", false, "Print lock rules")
+	flag.BoolVar(
+		&flagCheck,
+		"check",
+		false,
+		"Validate the lock rules with the backend without loading them",
+	)
+	flag.BoolVar(
+		&flagDiff,
+		"diff",
+		false,
+		"Print a diff of the lock rules against what's currently loaded",
+	)
+	flag.BoolVar(
+		&flagDaemon,
+		"daemon",
+		false,
+		"Keep running after enabling, re-resolving hostname destinations",
+	)
+	flag.DurationVar(
+		&flagResolveInterval,
+		"resolve-interval",
+		5*time.Minute,
+		"How often -daemon re-resolves hostname destinations",
+	)
+	flag.StringVar(
+		&flagAllowlistPath,
+		"allowlist",
+		"",
+		"YAML/JSON file of named allow/deny groups and per-interface overrides",
+	)
 	flag.Parse()
 }
 
@@ -127,30 +303,170 @@ func init() {
 	if flagEnableLock && flagDisableLock {
 		log.Fatal("Enable and disable are mutually exclusive")
 	}
-	if !flagEnableLock && !flagDisableLock && !flagPrintLockRules {
+	if !flagEnableLock && !flagDisableLock && !flagPrintLockRules && !flagCheck && !flagDiff {
 		flag.PrintDefaults()
 		os.Exit(64)
 	}
 }
 
+// buildConfig loads the config file (explicit -config or the implicit
+// search paths), selects -profile, then applies every explicitly-set CLI
+// flag on top of it, so flags always win over the file.
+func buildConfig() config.Config {
+	file, err := config.Find(flagConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := file.Select(flagProfile)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "default-configuration-path":
+			cfg.DefaultConfigurationPath = flagDefaultConfigurationPath
+		case "backend":
+			cfg.Backend = flagBackend
+		case "allow-incoming":
+			cfg.AllowIncoming = &flagAllowIncoming
+		case "allow-outgoing":
+			cfg.AllowOutgoing = &flagAllowOutgoing
+		case "allow-private-networks":
+			cfg.AllowPrivateNetworks = &flagAllowPrivateNetworks
+		case "allow-icmp":
+			cfg.AllowICMP = &flagAllowICMP
+		case "pass", "file":
+			cfg.Destinations = destinations
+		case "skip":
+			cfg.Interfaces = interfaces
+		case "pass-user":
+			cfg.PassUsers = passUserSpecs
+		case "pass-group":
+			cfg.PassGroups = passGroupSpecs
+		case "allowlist":
+			cfg.AllowlistPath = flagAllowlistPath
+		case "daemon":
+			cfg.Daemon = &flagDaemon
+		case "resolve-interval":
+			cfg.ResolveInterval = flagResolveInterval
+		}
+	})
+	if cfg.ResolveInterval == 0 {
+		cfg.ResolveInterval = flagResolveInterval
+	}
+	for _, path := range cfg.VPNConfigFiles {
+		hosts, err := vpnConfigDestinations(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Destinations = append(cfg.Destinations, hosts...)
+	}
+	return cfg
+}
+
+func newBackend(cfg config.Config) Backend {
+	name := cfg.Backend
+	if name == "" {
+		switch runtime.GOOS {
+		case "linux":
+			name = "nft"
+		default:
+			name = "pf"
+		}
+	}
+	switch name {
+	case "pf":
+		al := allowlist.New()
+		if cfg.AllowlistPath != "" {
+			var err error
+			if al, err = allowlist.Load(cfg.AllowlistPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		passUsers := resolvePassRules(cfg.PassUsers, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		passGroups := resolvePassRules(cfg.PassGroups, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		return NewPF(
+			cfg.DefaultConfigurationPath,
+			config.Bool(cfg.AllowIncoming),
+			config.Bool(cfg.AllowOutgoing),
+			config.Bool(cfg.AllowPrivateNetworks),
+			config.Bool(cfg.AllowICMP),
+			cfg.Destinations,
+			cfg.Interfaces,
+			passUsers,
+			passGroups,
+			al,
+		)
+	case "nft":
+		return NewNFT(
+			config.Bool(cfg.AllowIncoming),
+			config.Bool(cfg.AllowOutgoing),
+			config.Bool(cfg.AllowPrivateNetworks),
+			config.Bool(cfg.AllowICMP),
+			cfg.Destinations,
+			cfg.Interfaces,
+		)
+	default:
+		log.Fatalf("unknown backend %q", name)
+		return nil
+	}
+}
+
 func main() {
-	pf := NewPF(
-		flagDefaultConfigurationPath,
-		flagAllowIncoming,
-		flagAllowOutgoing,
-		flagAllowPrivateNetworks,
-		flagAllowICMP,
-		destinations,
-		interfaces,
-	)
+	cfg := buildConfig()
+	backend := newBackend(cfg)
 	if flagPrintLockRules {
-		fmt.Println(pf.BuildLockRules())
+		fmt.Println(backend.BuildLockRules())
+	}
+	if flagCheck {
+		checker, ok := backend.(Checker)
+		if !ok {
+			log.Fatal("-check is not supported by this backend")
+		}
+		if err := checker.Check(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("OK")
+	}
+	if flagDiff {
+		differ, ok := backend.(Differ)
+		if !ok {
+			log.Fatal("-diff is not supported by this backend")
+		}
+		fmt.Println(differ.Diff())
 	}
 	if flagEnableLock {
-		pf.EnableLock()
+		backend.EnableLock()
 		fmt.Println("OK")
+		if config.Bool(cfg.Daemon) {
+			runDaemon(backend, cfg.ResolveInterval)
+		}
 	} else if flagDisableLock {
-		pf.DisableLock()
+		backend.DisableLock()
 		fmt.Println("OK")
 	}
 }
+
+func runDaemon(backend Backend, interval time.Duration) {
+	daemon, ok := backend.(Daemon)
+	if !ok {
+		log.Fatal("-daemon is not supported by this backend")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigterm
+		cancel()
+	}()
+	daemon.RunDaemon(ctx, interval)
+}
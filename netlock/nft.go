@@ -0,0 +1,445 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// Network-header offsets for the source/destination address fields, shared
+// by the IPv4 and IPv6 CIDR matchers below.
+const (
+	ipv4SrcOffset uint32 = 12
+	ipv4DstOffset uint32 = 16
+	ipv6SrcOffset uint32 = 8
+	ipv6DstOffset uint32 = 24
+)
+
+const nftTableName string = "netlock"
+
+func NewNFT(
+	allowIncoming bool,
+	allowOutgoing bool,
+	allowPrivateNetworks bool,
+	allowICMP bool,
+	destinations []string,
+	interfaces []string,
+) *NFT {
+	return &NFT{
+		tableName:            nftTableName,
+		allowIncoming:        allowIncoming,
+		allowOutgoing:        allowOutgoing,
+		allowPrivateNetworks: allowPrivateNetworks,
+		allowICMP:            allowICMP,
+		destinations:         destinations,
+		interfaces:           interfaces,
+	}
+}
+
+// NFT programs the kernel's nftables directly over netlink, so it needs no
+// pfctl/iptables/nft binary on $PATH.
+type NFT struct {
+	tableName            string
+	allowIncoming        bool
+	allowOutgoing        bool
+	allowPrivateNetworks bool
+	allowICMP            bool
+	destinations         []string
+	interfaces           []string
+}
+
+func (n *NFT) EnableLock() {
+	if n.isEnabled() {
+		log.Printf("netlock: table %q already exists, replacing it", n.tableName)
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.flush(conn)
+	table := conn.AddTable(&nftables.Table{
+		Name:   n.tableName,
+		Family: nftables.TableFamilyINet,
+	})
+	skipSet := n.addSkipSet(conn, table)
+	destSet4, destSet6 := n.addDestinationsSets(conn, table)
+	input := conn.AddChain(&nftables.Chain{
+		Name:     "input",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   policyPtr(n.allowIncoming),
+	})
+	output := conn.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   policyPtr(n.allowOutgoing),
+	})
+	for _, chain := range []*nftables.Chain{input, output} {
+		n.addSkipRule(conn, table, chain, skipSet, chain == input)
+		if n.allowICMP {
+			n.addICMPRules(conn, table, chain)
+		}
+		if n.allowPrivateNetworks {
+			n.addPrivateNetworkRules(conn, table, chain, chain == input)
+		}
+	}
+	n.addDestinationsRules(conn, table, output, destSet4, destSet6)
+	if err := conn.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (n *NFT) DisableLock() {
+	if !n.isEnabled() {
+		log.Printf("netlock: table %q is not loaded, nothing to disable", n.tableName)
+		return
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.flush(conn)
+	if err := conn.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (n *NFT) isEnabled() bool {
+	conn, err := nftables.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tables, err := conn.ListTables()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, table := range tables {
+		if table.Name == n.tableName && table.Family == nftables.TableFamilyINet {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildLockRules renders the rules NFT would install in nft(8) syntax, for
+// -print; EnableLock never parses this back, it talks to netlink directly.
+func (n *NFT) BuildLockRules() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "table inet %s {\n", n.tableName)
+	interfaces := append([]string{"lo"}, n.interfaces...)
+	fmt.Fprintf(&buf, "\tset skip { type ifname; elements = { %s } }\n", strings.Join(interfaces, ", "))
+	destinations4, destinations6 := splitDestinationsByFamily(n.destinations)
+	fmt.Fprintf(&buf, "\tset %s4 { type ipv4_addr; flags interval; elements = { %s } }\n",
+		n.tableName, strings.Join(destinations4, ", "))
+	fmt.Fprintf(&buf, "\tset %s6 { type ipv6_addr; flags interval; elements = { %s } }\n",
+		n.tableName, strings.Join(destinations6, ", "))
+	buf.WriteString("\tchain input {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook input priority filter; policy %s;\n", policyName(n.allowIncoming))
+	buf.WriteString("\t\tmeta iifname @skip accept\n")
+	if n.allowICMP {
+		buf.WriteString("\t\tmeta l4proto { icmp, icmpv6 } accept\n")
+	}
+	if n.allowPrivateNetworks {
+		buf.WriteString("\t\tip saddr { 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 169.254.0.0/16 } accept\n")
+		buf.WriteString("\t\tip6 saddr { fe80::/10, fc00::/7 } accept\n")
+	}
+	buf.WriteString("\t}\n")
+	buf.WriteString("\tchain output {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook output priority filter; policy %s;\n", policyName(n.allowOutgoing))
+	buf.WriteString("\t\tmeta oifname @skip accept\n")
+	if n.allowICMP {
+		buf.WriteString("\t\tmeta l4proto { icmp, icmpv6 } accept\n")
+	}
+	if n.allowPrivateNetworks {
+		buf.WriteString("\t\tip daddr { 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 169.254.0.0/16 } accept\n")
+		buf.WriteString("\t\tip6 daddr { fe80::/10, fc00::/7 } accept\n")
+	}
+	fmt.Fprintf(&buf, "\t\tip daddr @%s4 accept\n", n.tableName)
+	fmt.Fprintf(&buf, "\t\tip6 daddr @%s6 accept\n", n.tableName)
+	buf.WriteString("\t}\n")
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func (n *NFT) flush(conn *nftables.Conn) {
+	tables, err := conn.ListTables()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, table := range tables {
+		if table.Name == n.tableName && table.Family == nftables.TableFamilyINet {
+			conn.DelTable(table)
+		}
+	}
+}
+
+func (n *NFT) addSkipSet(conn *nftables.Conn, table *nftables.Table) *nftables.Set {
+	set := &nftables.Set{
+		Table:   table,
+		Name:    "skip",
+		KeyType: nftables.TypeIFName,
+	}
+	elements := make([]nftables.SetElement, 0, len(n.interfaces)+1)
+	for _, name := range append([]string{"lo"}, n.interfaces...) {
+		elements = append(elements, nftables.SetElement{Key: ifnameBytes(name)})
+	}
+	if err := conn.AddSet(set, elements); err != nil {
+		log.Fatal(err)
+	}
+	return set
+}
+
+// addDestinationsSets builds the IPv4 and IPv6 interval sets backing the
+// -pass/-file destinations: each CIDR or bare IP in n.destinations is parsed
+// into its packed start/end address and added as a paired interval element,
+// the same way nft(8) itself expands "set { flags interval; elements = {...} }".
+func (n *NFT) addDestinationsSets(conn *nftables.Conn, table *nftables.Table) (v4Set, v6Set *nftables.Set) {
+	v4Set = &nftables.Set{
+		Table:    table,
+		Name:     n.tableName + "4",
+		KeyType:  nftables.TypeIPAddr,
+		Interval: true,
+	}
+	v6Set = &nftables.Set{
+		Table:    table,
+		Name:     n.tableName + "6",
+		KeyType:  nftables.TypeIP6Addr,
+		Interval: true,
+	}
+	var v4Elements, v6Elements []nftables.SetElement
+	for _, destination := range n.destinations {
+		start, end, err := parseDestinationRange(destination)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ip4 := start.To4(); ip4 != nil {
+			v4Elements = append(v4Elements, intervalElements(ip4, end.To4())...)
+		} else {
+			v6Elements = append(v6Elements, intervalElements(start.To16(), end.To16())...)
+		}
+	}
+	if err := conn.AddSet(v4Set, v4Elements); err != nil {
+		log.Fatal(err)
+	}
+	if err := conn.AddSet(v6Set, v6Elements); err != nil {
+		log.Fatal(err)
+	}
+	return v4Set, v6Set
+}
+
+// parseDestinationRange parses a literal destination (bare IP or CIDR) into
+// its inclusive start and end addresses.
+func parseDestinationRange(destination string) (start net.IP, end net.IP, err error) {
+	if ip, ipNet, err := net.ParseCIDR(destination); err == nil {
+		return ip.Mask(ipNet.Mask), lastAddr(ipNet), nil
+	}
+	ip := net.ParseIP(destination)
+	if ip == nil {
+		return nil, nil, fmt.Errorf("invalid destination %q", destination)
+	}
+	return ip, ip, nil
+}
+
+// lastAddr returns the broadcast/highest address of ipNet.
+func lastAddr(ipNet *net.IPNet) net.IP {
+	last := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		last[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return last
+}
+
+// intervalElements renders an inclusive [start, end] range as the paired
+// start/IntervalEnd elements nftables interval sets expect: the end element
+// marks the first address the interval no longer matches.
+func intervalElements(start, end []byte) []nftables.SetElement {
+	return []nftables.SetElement{
+		{Key: append([]byte(nil), start...)},
+		{Key: nextAddr(end), IntervalEnd: true},
+	}
+}
+
+// nextAddr returns addr+1, wrapping like an odometer (good enough here since
+// overflowing the top of the address space just drops the open-ended tail).
+func nextAddr(addr []byte) []byte {
+	next := append([]byte(nil), addr...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// splitDestinationsByFamily separates n.destinations for the nft(8)-syntax
+// rendering in BuildLockRules, mirroring the family split addDestinationsSets
+// performs against the real netlink sets.
+func splitDestinationsByFamily(destinations []string) (v4, v6 []string) {
+	for _, destination := range destinations {
+		start, _, err := parseDestinationRange(destination)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if start.To4() != nil {
+			v4 = append(v4, destination)
+		} else {
+			v6 = append(v6, destination)
+		}
+	}
+	return v4, v6
+}
+
+func (n *NFT) addSkipRule(
+	conn *nftables.Conn,
+	table *nftables.Table,
+	chain *nftables.Chain,
+	skipSet *nftables.Set,
+	useInput bool,
+) {
+	key := expr.MetaKeyOIFNAME
+	if useInput {
+		key = expr.MetaKeyIIFNAME
+	}
+	conn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: key, Register: 1},
+			&expr.Lookup{SourceRegister: 1, SetName: skipSet.Name},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+}
+
+func (n *NFT) addICMPRules(conn *nftables.Conn, table *nftables.Table, chain *nftables.Chain) {
+	for _, proto := range []byte{unix.IPPROTO_ICMP, unix.IPPROTO_ICMPV6} {
+		conn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+				&expr.Verdict{Kind: expr.VerdictAccept},
+			},
+		})
+	}
+}
+
+func (n *NFT) addPrivateNetworkRules(
+	conn *nftables.Conn,
+	table *nftables.Table,
+	chain *nftables.Chain,
+	useSource bool,
+) {
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"} {
+		n.addCIDRRule(conn, table, chain, cidr, useSource, ipv4SrcOffset, ipv4DstOffset)
+	}
+	for _, cidr := range []string{"fe80::/10", "fc00::/7"} {
+		n.addCIDRRule(conn, table, chain, cidr, useSource, ipv6SrcOffset, ipv6DstOffset)
+	}
+}
+
+func (n *NFT) addCIDRRule(
+	conn *nftables.Conn,
+	table *nftables.Table,
+	chain *nftables.Chain,
+	cidr string,
+	useSource bool,
+	srcOffset uint32,
+	dstOffset uint32,
+) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mask := ipNet.Mask
+	offset := dstOffset
+	if useSource {
+		offset = srcOffset
+	}
+	family := byte(unix.NFPROTO_IPV6)
+	if ip.To4() != nil {
+		family = unix.NFPROTO_IPV4
+	}
+	conn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{family}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: uint32(len(mask))},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            uint32(len(mask)),
+				Mask:           mask,
+				Xor:            make([]byte, len(mask)),
+			},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip.Mask(mask)},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+}
+
+func (n *NFT) addDestinationsRules(
+	conn *nftables.Conn,
+	table *nftables.Table,
+	chain *nftables.Chain,
+	destSet4 *nftables.Set,
+	destSet6 *nftables.Set,
+) {
+	conn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV4}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: ipv4DstOffset, Len: 4},
+			&expr.Lookup{SourceRegister: 1, SetName: destSet4.Name},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+	conn.AddRule(&nftables.Rule{
+		Table: table,
+		Chain: chain,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV6}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: ipv6DstOffset, Len: 16},
+			&expr.Lookup{SourceRegister: 1, SetName: destSet6.Name},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+}
+
+func policyPtr(allow bool) *nftables.ChainPolicy {
+	policy := nftables.ChainPolicyDrop
+	if allow {
+		policy = nftables.ChainPolicyAccept
+	}
+	return &policy
+}
+
+func policyName(allow bool) string {
+	if allow {
+		return "accept"
+	}
+	return "drop"
+}
+
+func ifnameBytes(name string) []byte {
+	b := make([]byte, 16)
+	copy(b, name)
+	return b
+}
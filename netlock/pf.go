@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/x13a/netlock/netlock/internal/allowlist"
 )
 
 const pfDefaultConfigurationPath string = "/etc/pf.conf"
 
+// destinationsGroupName is the allowlist group that CLI-provided
+// destinations (-pass, -file) are folded into, so they share the same
+// table/pass-rule machinery as file-defined allowlist groups.
+const destinationsGroupName = "allowed_destinations"
+
 func NewPF(
 	defaultConfigurationPath string,
 	allowIncoming bool,
@@ -19,37 +32,77 @@ func NewPF(
 	allowICMP bool,
 	destinations []string,
 	interfaces []string,
+	passUsers []PassRule,
+	passGroups []PassRule,
+	al *allowlist.List,
 ) *PF {
 	if defaultConfigurationPath == "" {
 		defaultConfigurationPath = pfDefaultConfigurationPath
 	}
+	if al == nil {
+		al = allowlist.New()
+	}
+	literalDestinations, hostnameDestinations := splitDestinations(destinations)
+	if err := al.SetGroup(destinationsGroupName, literalDestinations); err != nil {
+		log.Fatal(err)
+	}
 	return &PF{
 		defaultConfigurationPath: defaultConfigurationPath,
-		destinationsTableName:    "allowed_destinations",
+		allowlist:                al,
 		allowIncoming:            allowIncoming,
 		allowOutgoing:            allowOutgoing,
 		allowPrivateNetworks:     allowPrivateNetworks,
 		allowICMP:                allowICMP,
-		destinations:             destinations,
+		hostnameDestinations:     hostnameDestinations,
 		interfaces:               interfaces,
+		passUsers:                passUsers,
+		passGroups:               passGroups,
+	}
+}
+
+// splitDestinations separates literal IPs/CIDRs, which go straight into the
+// pf table, from hostnames, which need resolving before they can.
+func splitDestinations(destinations []string) (literal []string, hostnames []string) {
+	for _, destination := range destinations {
+		if isLiteralDestination(destination) {
+			literal = append(literal, destination)
+		} else {
+			hostnames = append(hostnames, destination)
+		}
 	}
+	return literal, hostnames
+}
+
+func isLiteralDestination(destination string) bool {
+	if _, _, err := net.ParseCIDR(destination); err == nil {
+		return true
+	}
+	return net.ParseIP(destination) != nil
 }
 
 type PF struct {
 	ctlPath                  string
 	defaultConfigurationPath string
-	destinationsTableName    string
+	allowlist                *allowlist.List
 	allowIncoming            bool
 	allowOutgoing            bool
 	allowPrivateNetworks     bool
 	allowICMP                bool
-	destinations             []string
+	hostnameDestinations     []string
 	interfaces               []string
+	passUsers                []PassRule
+	passGroups               []PassRule
 }
 
 func (pf *PF) EnableLock() {
 	pf.preconfig()
-	pf.loadConfiguration(pf.makeLockConfiguration())
+	path := pf.makeLockConfiguration()
+	if err := pf.validate(path); err != nil {
+		os.Remove(path)
+		log.Fatalf("refusing to load an invalid ruleset: %v", err)
+	}
+	pf.loadConfiguration(path)
+	pf.refreshDestinations(context.Background())
 }
 
 func (pf *PF) DisableLock() {
@@ -57,6 +110,45 @@ func (pf *PF) DisableLock() {
 	pf.loadConfiguration(pf.defaultConfigurationPath)
 }
 
+// Check renders the lock rules and asks pfctl to parse them without loading
+// anything (-n), so a broken ruleset can be caught before EnableLock ever
+// touches live state.
+func (pf *PF) Check() error {
+	pf.preconfig()
+	path := pf.makeLockConfiguration()
+	defer os.Remove(path)
+	return pf.validate(path)
+}
+
+func (pf *PF) validate(path string) error {
+	output, err := exec.Command(pf.ctlPath, "-n", "-f", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// Diff renders the lock rules we'd install (including the destinations
+// table declaration), and returns a unified diff against the ruleset and
+// tables pf currently has loaded.
+func (pf *PF) Diff() string {
+	pf.preconfig()
+	current := pf.mustExec("-sr") + pf.mustExec("-sT")
+	wanted := pf.BuildLockRules()
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(wanted),
+		FromFile: "current",
+		ToFile:   "lock",
+		Context:  3,
+	}
+	text, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return text
+}
+
 func (pf *PF) isEnabled() bool {
 	return strings.Contains(
 		strings.ToLower(pf.mustExec("-s", "info")),
@@ -67,12 +159,14 @@ func (pf *PF) isEnabled() bool {
 // Based on Eddie
 func (pf *PF) BuildLockRules() string {
 	var buf strings.Builder
-	fmt.Fprintf(
-		&buf,
-		"table <%s> { %s }\n",
-		pf.destinationsTableName,
-		strings.Join(pf.destinations, ", "),
-	)
+	// Only destinationsGroupName needs a table: it's the one rule below that
+	// references <name> instead of a literal CIDR, because it's refreshed at
+	// runtime as hostnames resolve. Every other group's CIDRs are rendered
+	// directly as literal block/pass rules via Entries() further down, so
+	// declaring tables for them would just be dead pf state.
+	if group, ok := pf.allowlist.Group(destinationsGroupName); ok {
+		fmt.Fprintf(&buf, "table <%s> { %s }\n", group.Name, strings.Join(group.Allow, ", "))
+	}
 	buf.WriteString("set block-policy return\n")
 	interfaces := "lo0"
 	if len(pf.interfaces) > 0 {
@@ -122,11 +216,54 @@ func (pf *PF) BuildLockRules() string {
 	if pf.allowICMP {
 		buf.WriteString("pass quick proto { icmp, icmp6 } all\n")
 	}
-	fmt.Fprintf(
-		&buf,
-		"pass out quick from any to <%s>\n",
-		pf.destinationsTableName,
-	)
+	for _, rule := range pf.passUsers {
+		buf.WriteString(passRuleLine(rule, "user"))
+	}
+	for _, rule := range pf.passGroups {
+		buf.WriteString(passRuleLine(rule, "group"))
+	}
+	// Rendered most-specific-prefix first, so overlapping allow/deny CIDRs
+	// across groups resolve by longest-prefix-match rather than by "every
+	// deny beats every allow regardless of specificity."
+	for _, entry := range pf.allowlist.Entries() {
+		if entry.Deny {
+			fmt.Fprintf(&buf, "block drop quick from any to %s\n", entry.CIDR)
+		} else {
+			fmt.Fprintf(&buf, "pass out quick from any to %s\n", entry.CIDR)
+		}
+	}
+	if _, ok := pf.allowlist.Group(destinationsGroupName); ok {
+		// The destinations table is refreshed at runtime as hostnames
+		// resolve (refreshDestinations), so it needs its own rule beyond
+		// whatever literal CIDRs were already covered above.
+		fmt.Fprintf(&buf, "pass out quick from any to <%s>\n", destinationsGroupName)
+	}
+	for _, override := range pf.allowlist.Overrides {
+		for _, cidr := range override.Allow {
+			fmt.Fprintf(
+				&buf,
+				"pass out quick on %s from any to %s\n",
+				override.Interface,
+				cidr,
+			)
+		}
+	}
+	return buf.String()
+}
+
+// passRuleLine renders a single pass-user/pass-group kill-switch exception,
+// e.g. "pass out quick proto tcp from any to any port 1194 user 501".
+func passRuleLine(rule PassRule, matchKey string) string {
+	var buf strings.Builder
+	buf.WriteString("pass out quick ")
+	if rule.Proto != "" {
+		fmt.Fprintf(&buf, "proto %s ", rule.Proto)
+	}
+	buf.WriteString("from any to any ")
+	if rule.Port != "" {
+		fmt.Fprintf(&buf, "port %s ", rule.Port)
+	}
+	fmt.Fprintf(&buf, "%s %s\n", matchKey, rule.ID)
 	return buf.String()
 }
 
@@ -169,6 +306,46 @@ func (pf *PF) loadConfiguration(path string) string {
 	return pf.mustExec("-F", "all", "-f", path)
 }
 
+// refreshDestinations re-resolves hostnameDestinations and replaces the
+// table's contents in place, so a roaming VPN endpoint's new address takes
+// effect without reloading the whole ruleset. A no-op when there are no
+// hostnames to resolve.
+func (pf *PF) refreshDestinations(ctx context.Context) {
+	if len(pf.hostnameDestinations) == 0 {
+		return
+	}
+	addrs := resolveHostnames(ctx, pf.hostnameDestinations)
+	if group, ok := pf.allowlist.Group(destinationsGroupName); ok {
+		addrs = append(addrs, group.Allow...)
+	}
+	pf.replaceDestinationsTable(addrs)
+}
+
+func (pf *PF) replaceDestinationsTable(addrs []string) {
+	args := append([]string{"-t", destinationsGroupName, "-T", "replace"}, addrs...)
+	pf.mustExec(args...)
+}
+
+// RunDaemon re-resolves hostnameDestinations every interval, and immediately
+// on SIGHUP, until ctx is cancelled.
+func (pf *PF) RunDaemon(ctx context.Context, interval time.Duration) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pf.refreshDestinations(ctx)
+		case <-sighup:
+			pf.refreshDestinations(ctx)
+		}
+	}
+}
+
 func (pf *PF) mustExec(args ...string) string {
 	result, err := exec.Command(pf.ctlPath, args...).CombinedOutput()
 	if err != nil {
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+const resolveTimeout = 5 * time.Second
+
+// resolveHostnames resolves each hostname to its IPv4/IPv6 addresses,
+// deduplicates across all of them, and returns a sorted address list. A
+// hostname that fails to resolve is logged and skipped rather than failing
+// the whole batch, since a transient DNS hiccup shouldn't empty the table.
+func resolveHostnames(ctx context.Context, hostnames []string) []string {
+	seen := make(map[string]struct{})
+	for _, hostname := range hostnames {
+		lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, hostname)
+		cancel()
+		if err != nil {
+			log.Printf("resolve %s: %v", hostname, err)
+			continue
+		}
+		for _, addr := range addrs {
+			seen[addr.IP.String()] = struct{}{}
+		}
+	}
+	resolved := make([]string, 0, len(seen))
+	for addr := range seen {
+		resolved = append(resolved, addr)
+	}
+	sort.Strings(resolved)
+	return resolved
+}